@@ -0,0 +1,214 @@
+// Copyright © 2020 Siftrics
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hydra
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeTempFile(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	fp := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(fp, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	return fp
+}
+
+// newFakeHydraServer starts an httptest.Server that decodes each request as
+// a base64-JSON HydraRequest and hands it to handler to produce a response.
+func newFakeHydraServer(t *testing.T, handler func(w http.ResponseWriter, sr HydraRequest)) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var sr HydraRequest
+		if err := json.NewDecoder(r.Body).Decode(&sr); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		handler(w, sr)
+	}))
+}
+
+func TestRecognizeCtxPreservesFileIndexAcrossConcurrentBatches(t *testing.T) {
+	dir := t.TempDir()
+	const n = 7
+	filePaths := make([]string, n)
+	for i := 0; i < n; i++ {
+		filePaths[i] = writeTempFile(t, dir, fmt.Sprintf("f%d.png", i), fmt.Sprintf("content-%d", i))
+	}
+
+	srv := newFakeHydraServer(t, func(w http.ResponseWriter, sr HydraRequest) {
+		// A small delay makes it likely that multiple batches are
+		// in flight at once, exercising the concurrent case.
+		time.Sleep(5 * time.Millisecond)
+		rfs := RecognizedFiles{Rows: make([]RecognizedFile, len(sr.Files))}
+		for i, f := range sr.Files {
+			decoded, err := base64.StdEncoding.DecodeString(f.Base64File)
+			if err != nil {
+				t.Errorf("failed to decode base64 file contents: %v", err)
+			}
+			rfs.Rows[i] = RecognizedFile{
+				FileIndex:      i,
+				RecognizedText: map[string]interface{}{"content": string(decoded)},
+			}
+		}
+		json.NewEncoder(w).Encode(&rfs)
+	})
+	defer srv.Close()
+
+	client := &Client{apiKey: "test", baseURL: srv.URL}
+	cfg := Config{BatchSize: 3, MaxConcurrency: 2}
+	job, err := client.RecognizeCfg(cfg, "test-data-source", filePaths...)
+	if err != nil {
+		t.Fatalf("RecognizeCfg returned error: %v", err)
+	}
+
+	seen := make(map[int]string)
+	for rf := range job.Files() {
+		if rf.Error != "" {
+			t.Fatalf("unexpected per-file error: %v", rf.Error)
+		}
+		content, err := rf.Get("content")
+		if err != nil {
+			t.Fatalf("Get(\"content\") failed: %v", err)
+		}
+		seen[rf.FileIndex] = content
+	}
+	if jobErr, ok := <-job.ErrChan(); ok {
+		t.Fatalf("unexpected job error: %v", jobErr)
+	}
+
+	if len(seen) != n {
+		t.Fatalf("expected %v files, got %v", n, len(seen))
+	}
+	for i := 0; i < n; i++ {
+		want := fmt.Sprintf("content-%d", i)
+		if got := seen[i]; got != want {
+			t.Errorf("FileIndex %v: expected content %q, got %q", i, want, got)
+		}
+	}
+}
+
+func TestRecognizeCtxReportsPerBatch5xxWithoutAbortingJob(t *testing.T) {
+	dir := t.TempDir()
+	filePaths := []string{
+		writeTempFile(t, dir, "f0.png", "bad-0"),
+		writeTempFile(t, dir, "f1.png", "bad-1"),
+		writeTempFile(t, dir, "f2.png", "good-2"),
+		writeTempFile(t, dir, "f3.png", "good-3"),
+	}
+
+	srv := newFakeHydraServer(t, func(w http.ResponseWriter, sr HydraRequest) {
+		first, _ := base64.StdEncoding.DecodeString(sr.Files[0].Base64File)
+		if strings.HasPrefix(string(first), "bad") {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rfs := RecognizedFiles{Rows: make([]RecognizedFile, len(sr.Files))}
+		for i := range sr.Files {
+			rfs.Rows[i] = RecognizedFile{FileIndex: i}
+		}
+		json.NewEncoder(w).Encode(&rfs)
+	})
+	defer srv.Close()
+
+	client := &Client{apiKey: "test", baseURL: srv.URL}
+	cfg := Config{
+		BatchSize:      2,
+		MaxConcurrency: 2,
+		Retry:          RetryPolicy{MaxRetries: 1, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+	job, err := client.RecognizeCfg(cfg, "test-data-source", filePaths...)
+	if err != nil {
+		t.Fatalf("RecognizeCfg returned error: %v", err)
+	}
+
+	gotErr := make(map[int]bool)
+	gotOK := make(map[int]bool)
+	for rf := range job.Files() {
+		if rf.Error != "" {
+			gotErr[rf.FileIndex] = true
+		} else {
+			gotOK[rf.FileIndex] = true
+		}
+	}
+	if jobErr, ok := <-job.ErrChan(); ok {
+		t.Fatalf("expected no job-aborting error for a transient per-batch failure, got %v", jobErr)
+	}
+
+	for _, i := range []int{0, 1} {
+		if !gotErr[i] {
+			t.Errorf("expected FileIndex %v to carry a per-file error", i)
+		}
+	}
+	for _, i := range []int{2, 3} {
+		if !gotOK[i] {
+			t.Errorf("expected FileIndex %v to succeed", i)
+		}
+	}
+}
+
+func TestRecognizeCtxAbortsJobOn401WithoutStartingFurtherBatches(t *testing.T) {
+	dir := t.TempDir()
+	filePaths := []string{
+		writeTempFile(t, dir, "f0.png", "content-0"),
+		writeTempFile(t, dir, "f1.png", "content-1"),
+	}
+
+	var batchesStarted int32
+	srv := newFakeHydraServer(t, func(w http.ResponseWriter, sr HydraRequest) {
+		atomic.AddInt32(&batchesStarted, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	defer srv.Close()
+
+	// BatchSize 1 and MaxConcurrency 1 make batch dispatch strictly
+	// sequential, so the second batch is only ever attempted if the
+	// first batch's 401 failed to stop the job.
+	client := &Client{apiKey: "test", baseURL: srv.URL}
+	cfg := Config{BatchSize: 1, MaxConcurrency: 1}
+	job, err := client.RecognizeCfg(cfg, "test-data-source", filePaths...)
+	if err != nil {
+		t.Fatalf("RecognizeCfg returned error: %v", err)
+	}
+
+	for rf := range job.Files() {
+		t.Fatalf("expected no files delivered after a 401 abort, got %+v", rf)
+	}
+	jobErr, ok := <-job.ErrChan()
+	if !ok || jobErr == nil {
+		t.Fatal("expected ErrChan to deliver the 401 error")
+	}
+	if got := atomic.LoadInt32(&batchesStarted); got != 1 {
+		t.Fatalf("expected exactly 1 batch to be attempted before the job aborted, got %v", got)
+	}
+}
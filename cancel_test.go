@@ -0,0 +1,144 @@
+// Copyright © 2020 Siftrics
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hydra
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRecognizeCtxCancelsInFlightBatchesPromptly exercises the base64
+// (non-multipart) path specifically: two batches are dispatched
+// concurrently against a server that never responds, and then ctx is
+// canceled. Job's channels must close shortly after -- because the
+// in-flight requests are torn down immediately, not merely because further
+// batches stop being dispatched -- rather than waiting out however long the
+// server would otherwise have held the connections open.
+func TestRecognizeCtxCancelsInFlightBatchesPromptly(t *testing.T) {
+	dir := t.TempDir()
+	filePaths := []string{
+		writeTempFile(t, dir, "a.png", "content-a"),
+		writeTempFile(t, dir, "b.png", "content-b"),
+	}
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer func() {
+		close(release)
+		srv.Close()
+	}()
+
+	client := &Client{apiKey: "test", baseURL: srv.URL}
+	cfg := Config{BatchSize: 1, MaxConcurrency: 2}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	job, err := client.RecognizeCtx(ctx, cfg, "test-data-source", filePaths...)
+	if err != nil {
+		t.Fatalf("RecognizeCtx returned error: %v", err)
+	}
+
+	// Give both batches a chance to reach the server before canceling.
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for range job.Files() {
+		}
+		<-job.ErrChan()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Job's channels did not close promptly after ctx cancellation; a sibling batch outlived the abort")
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected both batches to unwind promptly after cancellation, took %v", elapsed)
+	}
+}
+
+// TestRecognizeCtxAbortCancelsSiblingInFlightBatchesPromptly exercises the
+// base64 (non-multipart) path's 401-abort handling specifically: one batch
+// gets a 401 while a sibling batch is already in flight and would
+// otherwise hang forever waiting on a response. The abort must cancel that
+// sibling's request immediately -- not merely stop further batches from
+// being dispatched -- so the job unwinds without waiting on it.
+func TestRecognizeCtxAbortCancelsSiblingInFlightBatchesPromptly(t *testing.T) {
+	dir := t.TempDir()
+	filePaths := []string{
+		writeTempFile(t, dir, "a.png", "trigger-401"),
+		writeTempFile(t, dir, "b.png", "hangs-forever"),
+	}
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var sr HydraRequest
+		if err := json.NewDecoder(r.Body).Decode(&sr); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+			return
+		}
+		decoded, err := base64.StdEncoding.DecodeString(sr.Files[0].Base64File)
+		if err != nil {
+			t.Errorf("failed to decode base64 file contents: %v", err)
+			return
+		}
+		if string(decoded) == "trigger-401" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		<-release
+	}))
+	defer func() {
+		close(release)
+		srv.Close()
+	}()
+
+	client := &Client{apiKey: "test", baseURL: srv.URL}
+	cfg := Config{BatchSize: 1, MaxConcurrency: 2}
+	job, err := client.RecognizeCfg(cfg, "test-data-source", filePaths...)
+	if err != nil {
+		t.Fatalf("RecognizeCfg returned error: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for range job.Files() {
+		}
+		<-job.ErrChan()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("job did not unwind promptly after a 401 aborted it; a sibling in-flight batch outlived the abort")
+	}
+}
@@ -21,11 +21,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"syscall"
@@ -240,7 +242,10 @@ Run ./hydra -h for more help.
 		fmt.Println("Uploading files...")
 	}
 
-	filesChan, err := client.RecognizeCfg(cfg, dataSourceId, inputFiles...)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	job, err := client.RecognizeCtx(ctx, cfg, dataSourceId, inputFiles...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
@@ -248,26 +253,45 @@ Run ./hydra -h for more help.
 	fmt.Fprintf(of, `{"Rows":[`)
 	isFirstFile := true
 	numFilesComplete := 0
+	filesChan := job.Files()
+	errChan := job.ErrChan()
+loop:
 	for {
-		rf, isOpen := <-filesChan
-		if !isOpen {
-			break
-		}
-		if !isFirstFile {
-			fmt.Fprintf(of, ",")
-		} else {
-			isFirstFile = false
-		}
-		jsonBytes, err := json.Marshal(rf)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "\nerror: failed to serialize JSON: %v\n", err)
-			os.Exit(1)
-		}
-		of.Write(jsonBytes)
+		select {
+		case rf, isOpen := <-filesChan:
+			if !isOpen {
+				filesChan = nil
+				if errChan == nil {
+					break loop
+				}
+				continue
+			}
+			if !isFirstFile {
+				fmt.Fprintf(of, ",")
+			} else {
+				isFirstFile = false
+			}
+			jsonBytes, err := json.Marshal(rf)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "\nerror: failed to serialize JSON: %v\n", err)
+				os.Exit(1)
+			}
+			of.Write(jsonBytes)
 
-		numFilesComplete++
-		if outputFile != "" {
-			fmt.Printf("%v out of %v input files are complete\n", numFilesComplete, len(inputFiles))
+			numFilesComplete++
+			if outputFile != "" {
+				fmt.Printf("%v out of %v input files are complete\n", numFilesComplete, len(inputFiles))
+			}
+		case jobErr, isOpen := <-errChan:
+			if !isOpen {
+				errChan = nil
+				if filesChan == nil {
+					break loop
+				}
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "\nerror: %v\n", jobErr)
+			os.Exit(1)
 		}
 	}
 	fmt.Fprintf(of, "]}\n")
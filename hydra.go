@@ -22,12 +22,23 @@ package hydra
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Config is used to consolidate the parameters to the function
@@ -35,7 +46,161 @@ import (
 // the number of parameters will grow unwieldy. This allows RecognizeCfg
 // interface to remain readable (few parameters) and unchanged over time.
 type Config struct {
-	DoFaster bool
+	DoFaster       bool
+	Retry          RetryPolicy
+	BatchSize      int
+	MaxConcurrency int
+
+	StreamingUploads     bool
+	DisableAutoStreaming bool
+	StreamingThreshold   int64
+}
+
+const (
+	defaultBatchSize      = 8
+	defaultMaxConcurrency = 4
+)
+
+// RetryPolicy configures how requests to the Hydra API are retried after
+// transient failures: responses whose status is in RetryableStatusCodes
+// (by default 429, 500, 502, 503 and 504) and temporary network errors.
+// It applies to every per-batch request made from the background
+// goroutine. Fields left at their zero value are replaced with the
+// package defaults (defaultMaxRetries, defaultMinBackoff,
+// defaultMaxBackoff, defaultRetryableStatusCodes), so callers only need
+// to set the fields they want to override.
+type RetryPolicy struct {
+	MaxRetries           int
+	MinBackoff           time.Duration
+	MaxBackoff           time.Duration
+	RetryableStatusCodes []int
+}
+
+const (
+	defaultMaxRetries = 5
+	defaultMinBackoff = 500 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+)
+
+var defaultRetryableStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxRetries == 0 {
+		p.MaxRetries = defaultMaxRetries
+	}
+	if p.MinBackoff == 0 {
+		p.MinBackoff = defaultMinBackoff
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = defaultMaxBackoff
+	}
+	if p.RetryableStatusCodes == nil {
+		p.RetryableStatusCodes = defaultRetryableStatusCodes
+	}
+	return p
+}
+
+func (p RetryPolicy) isRetryableStatus(code int) bool {
+	for _, c := range p.RetryableStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retryBackoff returns how long to sleep before the given retry attempt
+// (0-indexed), following MinBackoff * 2^attempt capped at MaxBackoff, plus
+// up to 25% random jitter so that many clients retrying at once don't all
+// wake up in lockstep.
+func retryBackoff(policy RetryPolicy, attempt int) time.Duration {
+	backoff := policy.MinBackoff * time.Duration(int64(1)<<uint(attempt))
+	if backoff <= 0 || backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(backoff)/4 + 1))
+	return backoff + jitter
+}
+
+// parseRetryAfter parses the Retry-After header, which may be either a
+// number of seconds or an HTTP-date. It returns 0 if the header is absent
+// or malformed.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// doWithRetry calls buildReq once per attempt to obtain the *http.Request to
+// send, retrying on retryable status codes and temporary network errors
+// according to policy. It never retries a request that completed with a
+// terminal 4xx (401 or 404), since those indicate a bad API key or data
+// source ID rather than a transient failure. Between attempts, the
+// previous response body is drained and closed so the underlying
+// connection can be reused. buildReq is called fresh for every attempt
+// (including the first) so that callers whose body can't simply be rewound
+// -- a streaming io.Pipe, for instance -- can rebuild it from scratch;
+// callers with an ordinary buffered body can just return the same
+// *http.Request each time, relying on req.GetBody to rewind it. The
+// inter-attempt sleep is interrupted promptly if ctx is done; the request
+// returned by buildReq should already carry ctx (e.g. via
+// http.NewRequestWithContext) so that httpClient.Do aborts an in-flight
+// attempt the same way.
+func doWithRetry(ctx context.Context, httpClient *http.Client, policy RetryPolicy, buildReq func() (*http.Request, error)) (*http.Response, error) {
+	policy = policy.withDefaults()
+	for attempt := 0; ; attempt++ {
+		req, err := buildReq()
+		if err != nil {
+			return nil, err
+		}
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := httpClient.Do(req)
+		if err == nil {
+			if resp.StatusCode == 401 || resp.StatusCode == 404 || !policy.isRetryableStatus(resp.StatusCode) {
+				return resp, nil
+			}
+		} else if netErr, ok := err.(net.Error); !ok || !netErr.Temporary() {
+			return nil, err
+		}
+		if attempt >= policy.MaxRetries {
+			if err != nil {
+				return nil, err
+			}
+			return resp, nil
+		}
+		wait := retryBackoff(policy, attempt)
+		if resp != nil {
+			if ra := parseRetryAfter(resp.Header.Get("Retry-After")); ra > 0 {
+				wait = ra
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
 }
 
 type HydraRequest struct {
@@ -121,14 +286,60 @@ type RecognizedFiles struct {
 
 type Client struct {
 	apiKey string
+
+	// baseURL overrides the Hydra API's base URL; it is only ever set by
+	// tests within this package, which point it at an httptest.Server
+	// instead of siftrics.com.
+	baseURL string
 }
 
 func NewClient(apiKey string) *Client {
 	return &Client{apiKey: apiKey}
 }
 
+// apiURL returns the URL a batch request for dataSourceId should be sent
+// to, defaulting to the production Hydra API.
+func (c *Client) apiURL(dataSourceId string) string {
+	base := c.baseURL
+	if base == "" {
+		base = "https://siftrics.com"
+	}
+	return fmt.Sprintf("%v/api/hydra/%v/", base, dataSourceId)
+}
+
+// Job represents an in-flight call to RecognizeCfg/Recognize, which may
+// dispatch several HTTP batches concurrently.
+type Job struct {
+	filesChan chan RecognizedFile
+	errChan   chan error
+}
+
+// Files returns the channel on which recognized files are delivered. Files
+// from different batches may arrive in any order relative to one another,
+// but each RecognizedFile.FileIndex always refers back to its position in
+// the filePaths passed to RecognizeCfg/Recognize. The channel is closed
+// once every batch has finished, or the job has aborted (see ErrChan).
+func (j *Job) Files() <-chan RecognizedFile {
+	return j.filesChan
+}
+
+// ErrChan receives at most one error and is then closed. A value is sent
+// only if some batch's initial HTTP request came back 401 or 404, meaning
+// the API key or data source ID is invalid; in that case the job aborts: no
+// further batches are started, every other batch's in-flight HTTP request
+// is canceled immediately rather than left to run out its retries, and
+// Files() is closed once those batches unwind. Batches that had not yet
+// started deliver no results; batches that were in flight report each of
+// their files through Files() with a non-empty Error (the cancellation
+// error). Transient per-batch failures are not sent here: they are
+// instead reported per-file through Files(), via a RecognizedFile with a
+// non-empty Error.
+func (j *Job) ErrChan() <-chan error {
+	return j.errChan
+}
+
 // Recognize is shorthand for calling RecognizeCfg with all the default config values.
-func (c *Client) Recognize(dataSourceId string, filePaths ...string) (<-chan RecognizedFile, error) {
+func (c *Client) Recognize(dataSourceId string, filePaths ...string) (*Job, error) {
 	return c.RecognizeCfg(
 		Config{
 			DoFaster: false,
@@ -138,92 +349,358 @@ func (c *Client) Recognize(dataSourceId string, filePaths ...string) (<-chan Rec
 	)
 }
 
-// RecognizeCfg uses the Hydra API to recognize all the text in the given files.
+// RecognizeCfg delegates to RecognizeCtx with context.Background(), which
+// never cancels or times out.
+func (c *Client) RecognizeCfg(cfg Config, dataSourceId string, filePaths ...string) (*Job, error) {
+	return c.RecognizeCtx(context.Background(), cfg, dataSourceId, filePaths...)
+}
+
+// inferMimeType infers a file's MIME type from the suffix (extension) of
+// its path. It returns an error if no known extension is recognized.
+func inferMimeType(fp string) (string, error) {
+	if len(fp) < 4 {
+		return "", fmt.Errorf("failed to infer MIME type from file path: %v", fp)
+	}
+	switch strings.ToLower(fp[len(fp)-4 : len(fp)]) {
+	case ".bmp":
+		return "image/bmp", nil
+	case ".gif":
+		return "image/gif", nil
+	case ".pdf":
+		return "application/pdf", nil
+	case ".png":
+		return "image/png", nil
+	case ".jpg":
+		return "image/jpg", nil
+	}
+	if len(fp) >= 5 && strings.ToLower(fp[len(fp)-5:len(fp)]) == ".jpeg" {
+		return "image/jpeg", nil
+	}
+	return "", fmt.Errorf("failed to infer MIME type from file path: %v", fp)
+}
+
+// RecognizeCtx uses the Hydra API to recognize all the text in the given
+// files, aborting promptly if ctx is canceled or its deadline elapses.
 //
-// If err != nil, then ioutil.ReadAll failed on a given file, a MIME type was
-// failed to be inferred from the suffix (extension) of a given filename, or
-// there was an error with the _initial_ HTTP request or response.
+// If err != nil, then a MIME type failed to be inferred from the suffix
+// (extension) of a given filename. Everything else, including reading each
+// file, sending the (possibly many) batched HTTP requests, and decoding
+// their responses, happens in a background goroutine and is reported
+// through the returned Job.
 //
-// This function blocks until receiving a response for the _initial_ HTTP request
-// to the Hydra API, so that non-200 responses for the initial request are conveyed
-// via the returned error. All remaining work, including any additional network
-// requests, is done in a separate goroutine. Accordingly, to avoid the blocking
-// nature of the initial network request, this function must be run in a separate
-// goroutine.
-func (c *Client) RecognizeCfg(cfg Config, dataSourceId string, filePaths ...string) (<-chan RecognizedFile, error) {
-	sr := HydraRequest{
-		Files:    make([]HydraRequestFile, len(filePaths), len(filePaths)),
-		DoFaster: cfg.DoFaster,
+// filePaths is split into chunks of cfg.BatchSize (default 8), and up to
+// cfg.MaxConcurrency (default 4) of those chunks are sent to the Hydra API
+// concurrently. Each RecognizedFile's FileIndex is adjusted by its chunk's
+// offset into filePaths, so indices are stable regardless of batching.
+//
+// Once ctx is done, no further batches are started, any in-flight HTTP
+// request is aborted, file reading for batches still in progress stops
+// early, and Job's channels are closed as soon as the goroutines already
+// running unwind -- no goroutine is left behind waiting on ctx.
+func (c *Client) RecognizeCtx(ctx context.Context, cfg Config, dataSourceId string, filePaths ...string) (*Job, error) {
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	maxConcurrency := cfg.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = defaultMaxConcurrency
 	}
+
+	mimeTypes := make([]string, len(filePaths))
 	for i, fp := range filePaths {
-		if len(fp) < 4 {
-			return nil, fmt.Errorf("failed to infer MIME type from file path: %v", fp)
-		}
-		switch strings.ToLower(fp[len(fp)-4 : len(fp)]) {
-		case ".bmp":
-			sr.Files[i].MimeType = "image/bmp"
-		case ".gif":
-			sr.Files[i].MimeType = "image/gif"
-		case ".pdf":
-			sr.Files[i].MimeType = "application/pdf"
-		case ".png":
-			sr.Files[i].MimeType = "image/png"
-		case ".jpg":
-			sr.Files[i].MimeType = "image/jpg"
-		default:
-			if len(fp) >= 5 && strings.ToLower(fp[len(fp)-5:len(fp)]) == ".jpeg" {
-				sr.Files[i].MimeType = "image/jpeg"
-			} else {
-				return nil, fmt.Errorf("failed to infer MIME type from file path: %v", fp)
+		mimeType, err := inferMimeType(fp)
+		if err != nil {
+			return nil, err
+		}
+		mimeTypes[i] = mimeType
+	}
+
+	job := &Job{
+		filesChan: make(chan RecognizedFile, 16),
+		errChan:   make(chan error, 1),
+	}
+
+	type batch struct {
+		offset    int
+		filePaths []string
+		mimeTypes []string
+	}
+	batches := make([]batch, 0, (len(filePaths)+batchSize-1)/batchSize)
+	for offset := 0; offset < len(filePaths); offset += batchSize {
+		end := offset + batchSize
+		if end > len(filePaths) {
+			end = len(filePaths)
+		}
+		batches = append(batches, batch{offset, filePaths[offset:end], mimeTypes[offset:end]})
+	}
+
+	go func() {
+		defer close(job.filesChan)
+		defer close(job.errChan)
+
+		// jobCtx is canceled the moment a batch aborts the job (401/404),
+		// so that every other batch's in-flight HTTP request is torn down
+		// immediately instead of running its retry/backoff cycle to
+		// completion.
+		jobCtx, cancelJob := context.WithCancel(ctx)
+		defer cancelJob()
+
+		sem := make(chan struct{}, maxConcurrency)
+		var wg sync.WaitGroup
+		var aborted int32
+	dispatch:
+		for _, b := range batches {
+			if atomic.LoadInt32(&aborted) != 0 {
+				break
+			}
+			select {
+			case <-jobCtx.Done():
+				break dispatch
+			case sem <- struct{}{}:
+			}
+			if jobCtx.Err() != nil {
+				<-sem
+				break
 			}
+			wg.Add(1)
+			go func(b batch) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				if err := c.recognizeBatch(jobCtx, cfg, dataSourceId, b.offset, b.filePaths, b.mimeTypes, job.filesChan); err != nil {
+					if atomic.CompareAndSwapInt32(&aborted, 0, 1) {
+						cancelJob()
+						job.errChan <- err
+					}
+				}
+			}(b)
+		}
+		wg.Wait()
+	}()
+
+	return job, nil
+}
+
+// defaultStreamingThreshold is the total batch size, in bytes, above which
+// a batch automatically switches to the streaming multipart upload format
+// unless Config.DisableAutoStreaming is set.
+const defaultStreamingThreshold = 32 * 1024 * 1024
+
+// useStreamingUploads decides whether a batch should be sent as
+// multipart/form-data rather than base64-encoded JSON: either the caller
+// opted in explicitly via Config.StreamingUploads, or the batch's total
+// file size crosses Config.StreamingThreshold (default 32MB) and automatic
+// switching hasn't been disabled.
+func useStreamingUploads(cfg Config, filePaths []string) bool {
+	if cfg.StreamingUploads {
+		return true
+	}
+	if cfg.DisableAutoStreaming {
+		return false
+	}
+	threshold := cfg.StreamingThreshold
+	if threshold <= 0 {
+		threshold = defaultStreamingThreshold
+	}
+	var total int64
+	for _, fp := range filePaths {
+		if fi, err := os.Stat(fp); err == nil {
+			total += fi.Size()
 		}
 	}
+	return total > threshold
+}
+
+// recognizeBatch reads filePaths, sends them as a single Hydra API request,
+// and writes each resulting RecognizedFile (with FileIndex adjusted by
+// offset) to out. Failures that are specific to this batch -- a file that
+// can't be read, a non-200 response other than 401/404, a decode error --
+// are reported as a RecognizedFile with a non-empty Error for every file
+// in the batch, so that other batches can keep making progress. Only a 401
+// or 404 response, which indicates the whole job is misconfigured, is
+// returned as an error, so the caller can abort the job entirely.
+func (c *Client) recognizeBatch(ctx context.Context, cfg Config, dataSourceId string, offset int, filePaths, mimeTypes []string, out chan<- RecognizedFile) error {
+	if useStreamingUploads(cfg, filePaths) {
+		return c.recognizeBatchMultipart(ctx, cfg, dataSourceId, offset, filePaths, mimeTypes, out)
+	}
+	sr := HydraRequest{
+		Files:    make([]HydraRequestFile, len(filePaths), len(filePaths)),
+		DoFaster: cfg.DoFaster,
+	}
 	for i, fp := range filePaths {
+		if ctx.Err() != nil {
+			deliverBatchError(out, offset, len(filePaths), ctx.Err())
+			return nil
+		}
 		fileContents, err := ioutil.ReadFile(fp)
 		if err != nil {
-			return nil, err
+			deliverBatchError(out, offset, len(filePaths), err)
+			return nil
 		}
+		sr.Files[i].MimeType = mimeTypes[i]
 		sr.Files[i].Base64File = base64.StdEncoding.EncodeToString(fileContents)
 	}
 	buf, err := json.Marshal(&sr)
 	if err != nil {
-		return nil, err
+		deliverBatchError(out, offset, len(filePaths), err)
+		return nil
 	}
-	// TODO: batch into 8-file requests
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://siftrics.com/api/hydra/%v/", dataSourceId), bytes.NewReader(buf))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL(dataSourceId), bytes.NewReader(buf))
 	if err != nil {
-		return nil, err
+		deliverBatchError(out, offset, len(filePaths), err)
+		return nil
 	}
-
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("authorization", fmt.Sprintf("Basic %v", c.apiKey))
 	var httpClient http.Client
-	resp, err := httpClient.Do(req)
+	resp, err := doWithRetry(ctx, &httpClient, cfg.Retry, func() (*http.Request, error) {
+		return req, nil
+	})
+	if err != nil {
+		deliverBatchError(out, offset, len(filePaths), err)
+		return nil
+	}
+	defer resp.Body.Close()
+	return decodeBatchResponse(resp, offset, len(filePaths), out)
+}
+
+// multipartFileMeta describes one file's MIME type within the JSON
+// "metadata" part of a streaming multipart upload; the file's bytes
+// themselves travel in their own "file_N" part.
+type multipartFileMeta struct {
+	MimeType string
+}
+
+// multipartMetadata is the JSON payload carried by the "metadata" part of
+// a streaming multipart upload, mirroring HydraRequest minus the inline
+// base64 file contents.
+type multipartMetadata struct {
+	Files    []multipartFileMeta `json:"files"`
+	DoFaster bool
+}
+
+// newMultipartRequest builds one attempt's multipart/form-data request for
+// uploading filePaths, streaming each file directly from disk through a
+// fresh io.Pipe instead of buffering its base64-encoded contents in memory.
+// The server is told to expect this wire format via the X-Hydra-Upload
+// header. It's called once per retry attempt by doWithRetry, since a piped
+// body can't be rewound and replayed the way an in-memory buffer can --
+// each attempt gets its own pipe, goroutine, and re-opened files.
+func (c *Client) newMultipartRequest(ctx context.Context, cfg Config, dataSourceId string, filePaths, mimeTypes []string) (*http.Request, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		defer pw.Close()
+		meta := multipartMetadata{
+			Files:    make([]multipartFileMeta, len(mimeTypes)),
+			DoFaster: cfg.DoFaster,
+		}
+		for i, mimeType := range mimeTypes {
+			meta.Files[i].MimeType = mimeType
+		}
+		metaBytes, err := json.Marshal(&meta)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if err := mw.WriteField("metadata", string(metaBytes)); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		for i, fp := range filePaths {
+			if ctx.Err() != nil {
+				pw.CloseWithError(ctx.Err())
+				return
+			}
+			if err := copyFilePart(mw, i, fp); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		if err := mw.Close(); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL(dataSourceId), pr)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("X-Hydra-Upload", "multipart")
+	req.Header.Set("authorization", fmt.Sprintf("Basic %v", c.apiKey))
+	return req, nil
+}
+
+// recognizeBatchMultipart sends filePaths as multipart/form-data, retrying
+// transient failures the same way the base64 JSON path does: each retry
+// attempt calls newMultipartRequest again, re-opening every file and
+// streaming it through a brand new io.Pipe.
+func (c *Client) recognizeBatchMultipart(ctx context.Context, cfg Config, dataSourceId string, offset int, filePaths, mimeTypes []string, out chan<- RecognizedFile) error {
+	var httpClient http.Client
+	resp, err := doWithRetry(ctx, &httpClient, cfg.Retry, func() (*http.Request, error) {
+		return c.newMultipartRequest(ctx, cfg, dataSourceId, filePaths, mimeTypes)
+	})
+	if err != nil {
+		deliverBatchError(out, offset, len(filePaths), err)
+		return nil
+	}
+	defer resp.Body.Close()
+	return decodeBatchResponse(resp, offset, len(filePaths), out)
+}
+
+// copyFilePart streams the file at fp into a new "file_N" part of mw.
+func copyFilePart(mw *multipart.Writer, i int, fp string) error {
+	f, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	part, err := mw.CreateFormFile(fmt.Sprintf("file_%d", i), filepath.Base(fp))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, f)
+	return err
+}
+
+// decodeBatchResponse interprets resp as the result of a single batch
+// request, delivering recognized files (FileIndex adjusted by offset) or,
+// for non-fatal failures, a RecognizedFile with a non-empty Error for each
+// of the n files in the batch. Only a 401 or 404 status, which indicates
+// the whole job is misconfigured, is returned as an error.
+func decodeBatchResponse(resp *http.Response, offset, n int, out chan<- RecognizedFile) error {
 	if resp.StatusCode == 401 {
-		return nil, fmt.Errorf("Invalid API key; Received 401 Unauthorized from initial HTTP request to the Hydra API.\n")
+		return fmt.Errorf("Invalid API key; Received 401 Unauthorized from the Hydra API.\n")
 	} else if resp.StatusCode == 404 {
-		return nil, fmt.Errorf("Received 404 Not Found --- Invalid data source ID. (Note that the name of the data source is NOT necessarily the ID of the data source. The ID of the data source is listed on its page on siftrics.com. Spaces are usually replaced by hyphens.)\n")
+		return fmt.Errorf("Received 404 Not Found --- Invalid data source ID. (Note that the name of the data source is NOT necessarily the ID of the data source. The ID of the data source is listed on its page on siftrics.com. Spaces are usually replaced by hyphens.)\n")
 	} else if resp.StatusCode != 200 {
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return nil, fmt.Errorf("Non-200 response from intial HTTP request to the Hydra API. Status of inital HTTP response: %v. Furthermore, failed to read body of initial HTTP response.", resp.StatusCode)
+			deliverBatchError(out, offset, n, fmt.Errorf("Non-200 response from HTTP request to the Hydra API. Status of HTTP response: %v. Furthermore, failed to read body of HTTP response.", resp.StatusCode))
+			return nil
 		}
-		return nil, fmt.Errorf("Non-200 response from intial HTTP request to the Hydra API. Status of inital HTTP response: %v. Body of initial HTTP response:\n%v", resp.StatusCode, string(body))
+		deliverBatchError(out, offset, n, fmt.Errorf("Non-200 response from HTTP request to the Hydra API. Status of HTTP response: %v. Body of HTTP response:\n%v", resp.StatusCode, string(body)))
+		return nil
 	}
 	var rfs RecognizedFiles
 	if err := json.NewDecoder(resp.Body).Decode(&rfs); err != nil {
-		return nil, fmt.Errorf("This should never happen and is not your fault: failed to decode body of initial HTTP request; error: %v", err)
+		deliverBatchError(out, offset, n, fmt.Errorf("This should never happen and is not your fault: failed to decode body of HTTP response; error: %v", err))
+		return nil
 	}
+	for _, rf := range rfs.Rows {
+		rf.FileIndex += offset
+		out <- rf
+	}
+	return nil
+}
 
-	filesChan := make(chan RecognizedFile, 16)
-	go func() {
-		for _, rf := range rfs.Rows {
-			filesChan <- rf
-		}
-		close(filesChan)
-	}()
-	return filesChan, nil
+// deliverBatchError reports err as the Error of a RecognizedFile for each
+// of the n files in a batch starting at offset, preserving FileIndex.
+func deliverBatchError(out chan<- RecognizedFile, offset, n int, err error) {
+	for i := 0; i < n; i++ {
+		out <- RecognizedFile{Error: err.Error(), FileIndex: offset + i}
+	}
 }
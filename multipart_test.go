@@ -0,0 +1,319 @@
+// Copyright © 2020 Siftrics
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hydra
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeTempFileOfSize writes a file of exactly size bytes, for tests that
+// need to cross Config.StreamingThreshold.
+func writeTempFileOfSize(t *testing.T, dir, name string, size int) string {
+	t.Helper()
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	fp := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(fp, data, 0644); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	return fp
+}
+
+// decodeMultipartRequest reads a streaming multipart upload request and
+// returns its decoded "metadata" part plus the raw bytes of each "file_N"
+// part, in order.
+func decodeMultipartRequest(t *testing.T, r *http.Request) (multipartMetadata, [][]byte) {
+	t.Helper()
+	mr, err := r.MultipartReader()
+	if err != nil {
+		t.Fatalf("failed to construct multipart reader: %v", err)
+	}
+	var meta multipartMetadata
+	var files [][]byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read multipart part: %v", err)
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatalf("failed to read multipart part body: %v", err)
+		}
+		if part.FormName() == "metadata" {
+			if err := json.Unmarshal(body, &meta); err != nil {
+				t.Fatalf("failed to decode metadata part: %v", err)
+			}
+			continue
+		}
+		files = append(files, body)
+	}
+	return meta, files
+}
+
+func TestRecognizeBatchUsesMultipartWhenStreamingUploadsIsEnabled(t *testing.T) {
+	dir := t.TempDir()
+	filePaths := []string{
+		writeTempFile(t, dir, "a.png", "hello-a"),
+		writeTempFile(t, dir, "b.png", "hello-b"),
+	}
+
+	var gotUploadHeader string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUploadHeader = r.Header.Get("X-Hydra-Upload")
+		meta, files := decodeMultipartRequest(t, r)
+		rfs := RecognizedFiles{Rows: make([]RecognizedFile, len(files))}
+		for i, f := range files {
+			if meta.Files[i].MimeType != "image/png" {
+				t.Errorf("file %v: expected MimeType image/png, got %v", i, meta.Files[i].MimeType)
+			}
+			rfs.Rows[i] = RecognizedFile{
+				FileIndex:      i,
+				RecognizedText: map[string]interface{}{"content": string(f)},
+			}
+		}
+		json.NewEncoder(w).Encode(&rfs)
+	}))
+	defer srv.Close()
+
+	client := &Client{apiKey: "test", baseURL: srv.URL}
+	cfg := Config{StreamingUploads: true}
+	job, err := client.RecognizeCfg(cfg, "test-data-source", filePaths...)
+	if err != nil {
+		t.Fatalf("RecognizeCfg returned error: %v", err)
+	}
+
+	seen := make(map[int]string)
+	for rf := range job.Files() {
+		if rf.Error != "" {
+			t.Fatalf("unexpected per-file error: %v", rf.Error)
+		}
+		content, err := rf.Get("content")
+		if err != nil {
+			t.Fatalf("Get(\"content\") failed: %v", err)
+		}
+		seen[rf.FileIndex] = content
+	}
+	if jobErr, ok := <-job.ErrChan(); ok {
+		t.Fatalf("unexpected job error: %v", jobErr)
+	}
+
+	if gotUploadHeader != "multipart" {
+		t.Fatalf("expected X-Hydra-Upload: multipart, got %q", gotUploadHeader)
+	}
+	if seen[0] != "hello-a" || seen[1] != "hello-b" {
+		t.Fatalf("unexpected file contents: %v", seen)
+	}
+}
+
+func TestRecognizeBatchMultipartRetriesTransientFailures(t *testing.T) {
+	dir := t.TempDir()
+	filePaths := []string{writeTempFile(t, dir, "a.png", "retry-me")}
+
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, files := decodeMultipartRequest(t, r)
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		rfs := RecognizedFiles{Rows: []RecognizedFile{{
+			FileIndex:      0,
+			RecognizedText: map[string]interface{}{"content": string(files[0])},
+		}}}
+		json.NewEncoder(w).Encode(&rfs)
+	}))
+	defer srv.Close()
+
+	client := &Client{apiKey: "test", baseURL: srv.URL}
+	cfg := Config{
+		StreamingUploads: true,
+		Retry:            RetryPolicy{MaxRetries: 5, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+	}
+	job, err := client.RecognizeCfg(cfg, "test-data-source", filePaths...)
+	if err != nil {
+		t.Fatalf("RecognizeCfg returned error: %v", err)
+	}
+
+	var got []RecognizedFile
+	for rf := range job.Files() {
+		got = append(got, rf)
+	}
+	if jobErr, ok := <-job.ErrChan(); ok {
+		t.Fatalf("unexpected job error: %v", jobErr)
+	}
+	if len(got) != 1 || got[0].Error != "" {
+		t.Fatalf("expected a single successful file, got %+v", got)
+	}
+	if content, _ := got[0].Get("content"); content != "retry-me" {
+		t.Fatalf("expected content %q, got %q", "retry-me", content)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Fatalf("expected 3 attempts, got %v", n)
+	}
+}
+
+func TestRecognizeBatchMultipartCancelsPromptlyOnContextCancellation(t *testing.T) {
+	dir := t.TempDir()
+	filePaths := []string{writeTempFile(t, dir, "a.png", "slow")}
+
+	blockServer := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decodeMultipartRequest(t, r)
+		<-blockServer
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer func() {
+		close(blockServer)
+		srv.Close()
+	}()
+
+	client := &Client{apiKey: "test", baseURL: srv.URL}
+	cfg := Config{StreamingUploads: true}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	job, err := client.RecognizeCtx(ctx, cfg, "test-data-source", filePaths...)
+	if err != nil {
+		t.Fatalf("RecognizeCtx returned error: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for range job.Files() {
+		}
+		<-job.ErrChan()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("job did not wind down promptly after ctx cancellation")
+	}
+}
+
+func TestRecognizeBatchAutoSwitchesToMultipartAboveThreshold(t *testing.T) {
+	dir := t.TempDir()
+	half := defaultStreamingThreshold/2 + 1024*1024
+	filePaths := []string{
+		writeTempFileOfSize(t, dir, "a.png", int(half)),
+		writeTempFileOfSize(t, dir, "b.png", int(half)),
+	}
+
+	var gotUploadHeader string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUploadHeader = r.Header.Get("X-Hydra-Upload")
+		_, files := decodeMultipartRequest(t, r)
+		rfs := RecognizedFiles{Rows: make([]RecognizedFile, len(files))}
+		for i := range files {
+			rfs.Rows[i] = RecognizedFile{FileIndex: i}
+		}
+		json.NewEncoder(w).Encode(&rfs)
+	}))
+	defer srv.Close()
+
+	client := &Client{apiKey: "test", baseURL: srv.URL}
+	job, err := client.RecognizeCfg(Config{}, "test-data-source", filePaths...)
+	if err != nil {
+		t.Fatalf("RecognizeCfg returned error: %v", err)
+	}
+
+	for rf := range job.Files() {
+		if rf.Error != "" {
+			t.Fatalf("unexpected per-file error: %v", rf.Error)
+		}
+	}
+	if jobErr, ok := <-job.ErrChan(); ok {
+		t.Fatalf("unexpected job error: %v", jobErr)
+	}
+
+	if gotUploadHeader != "multipart" {
+		t.Fatalf("expected a batch totaling more than the default streaming threshold to auto-switch to multipart, got X-Hydra-Upload: %q", gotUploadHeader)
+	}
+}
+
+func TestRecognizeBatchDisableAutoStreamingSuppressesThresholdSwitch(t *testing.T) {
+	dir := t.TempDir()
+	half := defaultStreamingThreshold/2 + 1024*1024
+	filePaths := []string{
+		writeTempFileOfSize(t, dir, "a.png", int(half)),
+		writeTempFileOfSize(t, dir, "b.png", int(half)),
+	}
+
+	var gotUploadHeader string
+	var sawUploadHeader bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUploadHeader, sawUploadHeader = r.Header.Get("X-Hydra-Upload"), true
+		var sr HydraRequest
+		if err := json.NewDecoder(r.Body).Decode(&sr); err != nil {
+			t.Fatalf("failed to decode request body: %v", err)
+		}
+		rfs := RecognizedFiles{Rows: make([]RecognizedFile, len(sr.Files))}
+		for i, f := range sr.Files {
+			if _, err := base64.StdEncoding.DecodeString(f.Base64File); err != nil {
+				t.Errorf("failed to decode base64 file contents: %v", err)
+			}
+			rfs.Rows[i] = RecognizedFile{FileIndex: i}
+		}
+		json.NewEncoder(w).Encode(&rfs)
+	}))
+	defer srv.Close()
+
+	client := &Client{apiKey: "test", baseURL: srv.URL}
+	cfg := Config{DisableAutoStreaming: true}
+	job, err := client.RecognizeCfg(cfg, "test-data-source", filePaths...)
+	if err != nil {
+		t.Fatalf("RecognizeCfg returned error: %v", err)
+	}
+
+	for rf := range job.Files() {
+		if rf.Error != "" {
+			t.Fatalf("unexpected per-file error: %v", rf.Error)
+		}
+	}
+	if jobErr, ok := <-job.ErrChan(); ok {
+		t.Fatalf("unexpected job error: %v", jobErr)
+	}
+
+	if !sawUploadHeader {
+		t.Fatal("request never reached the server")
+	}
+	if gotUploadHeader == "multipart" {
+		t.Fatal("DisableAutoStreaming should have suppressed the threshold-based auto-switch to multipart")
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright © 2020 Siftrics
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package hydra
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func fastRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: 5,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+	}
+}
+
+func TestDoWithRetrySucceedsAfterTransientFailures(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var httpClient http.Client
+	resp, err := doWithRetry(context.Background(), &httpClient, fastRetryPolicy(), func() (*http.Request, error) {
+		return http.NewRequest("GET", srv.URL, nil)
+	})
+	if err != nil {
+		t.Fatalf("doWithRetry returned error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %v", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Fatalf("expected 3 attempts, got %v", got)
+	}
+}
+
+func TestDoWithRetryGivesUpAfterMaxRetries(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	policy := fastRetryPolicy()
+	policy.MaxRetries = 2
+	var httpClient http.Client
+	resp, err := doWithRetry(context.Background(), &httpClient, policy, func() (*http.Request, error) {
+		return http.NewRequest("GET", srv.URL, nil)
+	})
+	if err != nil {
+		t.Fatalf("doWithRetry returned error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 after exhausting retries, got %v", resp.StatusCode)
+	}
+	if got, want := atomic.LoadInt32(&attempts), int32(policy.MaxRetries+1); got != want {
+		t.Fatalf("expected %v attempts (1 initial + %v retries), got %v", want, policy.MaxRetries, got)
+	}
+}
+
+func TestDoWithRetryDoesNotRetryTerminal4xx(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusNotFound} {
+		status := status
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			var attempts int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&attempts, 1)
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+
+			var httpClient http.Client
+			resp, err := doWithRetry(context.Background(), &httpClient, fastRetryPolicy(), func() (*http.Request, error) {
+				return http.NewRequest("GET", srv.URL, nil)
+			})
+			if err != nil {
+				t.Fatalf("doWithRetry returned error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != status {
+				t.Fatalf("expected %v, got %v", status, resp.StatusCode)
+			}
+			if got := atomic.LoadInt32(&attempts); got != 1 {
+				t.Fatalf("expected exactly 1 attempt for status %v, got %v", status, got)
+			}
+		})
+	}
+}
+
+func TestDoWithRetryHonorsRetryAfterSeconds(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	// MinBackoff/MaxBackoff are tiny so that, absent the Retry-After
+	// header being honored, this test would complete in well under 1s.
+	policy := RetryPolicy{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	var httpClient http.Client
+	start := time.Now()
+	resp, err := doWithRetry(context.Background(), &httpClient, policy, func() (*http.Request, error) {
+		return http.NewRequest("GET", srv.URL, nil)
+	})
+	if err != nil {
+		t.Fatalf("doWithRetry returned error: %v", err)
+	}
+	resp.Body.Close()
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Fatalf("expected doWithRetry to wait out the 1s Retry-After header, only waited %v", elapsed)
+	}
+}
+
+func TestDoWithRetryStopsPromptlyOnContextCancellation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	policy := RetryPolicy{MaxRetries: 100, MinBackoff: time.Hour, MaxBackoff: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	var httpClient http.Client
+	done := make(chan struct{})
+	go func() {
+		_, err := doWithRetry(ctx, &httpClient, policy, func() (*http.Request, error) {
+			return http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+		})
+		if err == nil {
+			t.Error("expected an error once ctx was canceled, got nil")
+		}
+		close(done)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("doWithRetry did not return promptly after ctx cancellation")
+	}
+}
+
+func TestRetryBackoffStaysWithinBounds(t *testing.T) {
+	policy := RetryPolicy{MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	for attempt := 0; attempt < 10; attempt++ {
+		wait := retryBackoff(policy, attempt)
+		if wait < policy.MinBackoff {
+			t.Fatalf("attempt %v: wait %v below MinBackoff %v", attempt, wait, policy.MinBackoff)
+		}
+		if max := policy.MaxBackoff + policy.MaxBackoff/4 + 1; wait > max {
+			t.Fatalf("attempt %v: wait %v exceeds MaxBackoff+jitter %v", attempt, wait, max)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter(""); got != 0 {
+		t.Fatalf("expected 0 for empty header, got %v", got)
+	}
+	if got := parseRetryAfter("5"); got != 5*time.Second {
+		t.Fatalf("expected 5s for \"5\", got %v", got)
+	}
+	if got := parseRetryAfter("not-a-valid-header"); got != 0 {
+		t.Fatalf("expected 0 for a malformed header, got %v", got)
+	}
+	future := time.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(future); got <= 0 || got > 3*time.Second {
+		t.Fatalf("expected ~2s from an HTTP-date 2s in the future, got %v", got)
+	}
+}